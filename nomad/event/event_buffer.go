@@ -12,6 +12,17 @@ const (
 	defaultTTL = 1 * time.Hour
 )
 
+// ErrBufferIndexDropped is returned by StartAfter when the requested
+// resume point has already been evicted from the buffer, either because it
+// aged out or because the buffer hit its max size. The caller should reset
+// (e.g. via a fresh snapshot) rather than silently skip ahead.
+var ErrBufferIndexDropped = errors.New("event buffer: requested index no longer in buffer")
+
+// ErrItemDropped is returned by bufferItem.Next when the reader fell
+// behind and the item it was waiting to advance past was evicted from the
+// buffer before it got there.
+var ErrItemDropped = errors.New("event dropped from buffer")
+
 // eventBuffer is a single-writer, multiple-reader, fixed length concurrent
 // buffer of events that have been published. The buffer is
 // the head and tail of an atomically updated single-linked list. Atomic
@@ -55,6 +66,12 @@ type eventBuffer struct {
 
 	maxSize    int64
 	maxItemTTL time.Duration
+
+	// droppedIdx is the Index of the most recent item evicted from the
+	// buffer, either by advanceHead (maxSize) or prune (maxItemTTL). It's
+	// used by StartAfter to tell a resuming subscriber that the point it
+	// wants to resume from is gone rather than silently skipping ahead.
+	droppedIdx int64
 }
 
 // newEventBuffer creates an eventBuffer ready for use.
@@ -70,6 +87,62 @@ func newEventBuffer(size int64, maxItemTTL time.Duration) *eventBuffer {
 	return b
 }
 
+// StartAfter returns an anchor item such that calling Next on it (as
+// Subscription.forward does) delivers the first item with Index greater
+// than index: the last item in the buffer whose Index is less than or
+// equal to index, or the current tail if nothing published so far
+// qualifies (the caller then waits on it for whatever comes next).
+//
+// droppedIdx is the Index of the most recently evicted item, so a client
+// is only missing events if index is strictly less than it: index itself
+// was already delivered to them before it was evicted, and everything
+// after it is still in the buffer. In that case StartAfter returns
+// ErrBufferIndexDropped so the caller can fall back to a full snapshot
+// instead of silently skipping events.
+//
+// If index is exactly droppedIdx, the item that would normally serve as
+// the anchor (the one at droppedIdx) has itself been evicted, so it can't
+// be reached by walking forward from Head. The buffer's current Head is
+// the first item the client hasn't seen, so it's returned wrapped in an
+// already-resolved anchor instead. The same is done whenever Head's own
+// Index is greater than index (e.g. a client resuming from an index that
+// was never published on this subject).
+func (b *eventBuffer) StartAfter(index uint64) (*bufferItem, error) {
+	if index > 0 {
+		if dropped := uint64(atomic.LoadInt64(&b.droppedIdx)); dropped > 0 {
+			if index < dropped {
+				return nil, ErrBufferIndexDropped
+			}
+			if index == dropped {
+				return alreadyPublished(b.Head()), nil
+			}
+		}
+	}
+
+	item := b.Head()
+	if item.Events != nil && item.Index > index {
+		return alreadyPublished(item), nil
+	}
+	for {
+		next := item.NextNoBlock()
+		if next == nil || next.Index > index {
+			return item, nil
+		}
+		item = next
+	}
+}
+
+// alreadyPublished returns a bufferItem whose Next() immediately yields
+// next without blocking. It's used by StartAfter when the item that
+// should precede next in the chain has itself already been evicted from
+// the buffer, so there's no real bufferItem left to anchor on.
+func alreadyPublished(next *bufferItem) *bufferItem {
+	link := &bufferLink{ch: make(chan struct{}), droppedCh: make(chan struct{})}
+	close(link.ch)
+	link.next.Store(next)
+	return &bufferItem{link: link}
+}
+
 // Append a set of events from one raft operation to the buffer and notify
 // watchers. After calling append, the caller must not make any further
 // mutations to the events as they may have been exposed to subscribers in other
@@ -79,6 +152,29 @@ func (b *eventBuffer) Append(index uint64, events []Event) {
 	b.appendItem(newBufferItem(index, events))
 }
 
+// AppendErr appends a terminal error item to the buffer. Any reader that
+// is currently blocked in Next, or calls it later, will have the error
+// returned to them instead of blocking forever. No further items should be
+// appended to the buffer after this is called.
+func (b *eventBuffer) AppendErr(err error) {
+	item := newBufferItem(0, nil)
+	item.Err = err
+	b.appendItem(item)
+}
+
+// AppendBuffer splices next onto the tail of this buffer without copying
+// events into it or evicting anything. It's used to join a buffer built
+// separately (e.g. a snapshot) onto the buffer it was built from: next is
+// normally the result of calling NextLink on the other buffer's tail, so
+// readers that reach the end of this buffer continue into the other one
+// seamlessly, sharing its link rather than duplicating it.
+func (b *eventBuffer) AppendBuffer(next *bufferItem) {
+	oldTail := b.Tail()
+	oldTail.link.next.Store(next)
+	b.tail.Store(next)
+	close(oldTail.link.ch)
+}
+
 func (b *eventBuffer) appendItem(item *bufferItem) {
 	// Store the next item to the old tail
 	oldTail := b.Tail()
@@ -112,7 +208,9 @@ func (b *eventBuffer) advanceHead() {
 	close(old.link.droppedCh)
 	b.head.Store(next)
 	atomic.AddInt64(b.size, -1)
-
+	if old.Events != nil {
+		atomic.StoreInt64(&b.droppedIdx, int64(old.Index))
+	}
 }
 
 // Head returns the current head of the buffer. It will always exist but it may
@@ -138,17 +236,21 @@ func (b *eventBuffer) Len() int {
 	return int(atomic.LoadInt64(b.size))
 }
 
-func (b *eventBuffer) prune() {
+// prune evicts items older than maxItemTTL from the head of the buffer and
+// returns how many it dropped, so callers can report it as a metric.
+func (b *eventBuffer) prune() int {
+	var pruned int
 	for {
 		head := b.Head()
 		if b.Len() == 0 {
-			return
+			return pruned
 		}
 
 		if time.Since(head.createdAt) > b.maxItemTTL {
 			b.advanceHead()
+			pruned++
 		} else {
-			return
+			return pruned
 		}
 	}
 }
@@ -238,7 +340,7 @@ func (i *bufferItem) Next(ctx context.Context, forceClose <-chan struct{}) (*buf
 	// Check if the reader is too slow and the event buffer as discarded the event
 	select {
 	case <-i.link.droppedCh:
-		return nil, fmt.Errorf("event dropped from buffer")
+		return nil, ErrItemDropped
 	default:
 	}
 
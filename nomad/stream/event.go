@@ -13,3 +13,73 @@ type Event struct {
 	Index   uint64
 	Payload interface{}
 }
+
+// Payload may optionally be implemented by an Event's Payload to override
+// the subject it is routed to. This lets a payload's routing subject be
+// derived from a nested field (e.g. a Deployment's JobID) rather than the
+// Topic/Key set on the Event itself.
+type Payload interface {
+	// Subject returns the Topic/Key this event should be delivered under.
+	Subject() (Topic, string)
+}
+
+// ACLPayload may optionally be implemented by an Event's Payload to gate
+// delivery on the subscriber's ACL token. Payloads that don't implement it
+// are delivered to every subscriber regardless of token.
+type ACLPayload interface {
+	// HasReadPermission reports whether authz is allowed to read this
+	// event's payload.
+	HasReadPermission(authz Authorizer) bool
+}
+
+// Authorizer is the ACL capability-check surface a Payload needs to decide
+// whether a given token may read it. *acl.ACL satisfies this interface;
+// it's declared locally so this package doesn't depend on acl directly.
+type Authorizer interface {
+	// AllowNamespace reports whether the token represented by this
+	// Authorizer can read data in the given namespace.
+	AllowNamespace(ns string) bool
+}
+
+// TopicACLToken is the dedicated topic ACL token, policy, and role change
+// events are published on. EventPublisher watches it to automatically
+// invalidate subscriptions whose token was modified or revoked.
+const TopicACLToken Topic = "ACLToken"
+
+// Topics for the core Nomad objects streamed externally via the gRPC
+// EventStream service (see stream/grpc.Registry). Declared here, rather
+// than in the grpc package, so an in-process subscriber can reference
+// them without importing grpc.
+const (
+	TopicAllocation Topic = "Allocation"
+	TopicDeployment Topic = "Deployment"
+	TopicNode       Topic = "Node"
+	TopicJob        Topic = "Job"
+)
+
+// ACLTokenEvent is the Payload carried by Events on TopicACLToken.
+// SecretIDs lists every token secret ID that should be treated as stale as
+// of this event and have its subscriptions closed.
+type ACLTokenEvent struct {
+	SecretIDs []string
+}
+
+// topicSubject is the key eventBuffers are sharded by. Every published
+// Event is routed to the buffer for its own (Topic, Key), and, unless its
+// Key is already AllKeys, to the topic's AllKeys buffer as well so that
+// wildcard subscribers see the full topic stream from a single buffer
+// rather than fanning in across every key.
+type topicSubject struct {
+	Topic Topic
+	Key   string
+}
+
+// subject returns the topicSubject an Event should be routed to, honoring
+// a Payload's Subject method when it implements Payload.
+func subject(e Event) topicSubject {
+	if p, ok := e.Payload.(Payload); ok {
+		topic, key := p.Subject()
+		return topicSubject{Topic: topic, Key: key}
+	}
+	return topicSubject{Topic: e.Topic, Key: e.Key}
+}
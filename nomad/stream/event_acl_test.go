@@ -0,0 +1,50 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestEventPublisher_CloseSubscriptionsForTokens verifies that publishing a
+// TopicACLToken event force-closes every subscription made with one of the
+// named token secret IDs, and leaves subscriptions for other tokens alone.
+func TestEventPublisher_CloseSubscriptionsForTokens(t *testing.T) {
+	e := newTestPublisher(t, EventPublisherCfg{TopicBufferSize: 16})
+
+	revoked, err := e.Subscribe(&SubscribeRequest{
+		topics: map[Topic][]string{"Job": {AllKeys}},
+		Token:  "revoked-token",
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer revoked.Unsubscribe()
+
+	unaffected, err := e.Subscribe(&SubscribeRequest{
+		topics: map[Topic][]string{"Job": {AllKeys}},
+		Token:  "other-token",
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer unaffected.Unsubscribe()
+
+	e.Publish(1, []Event{{
+		Topic:   TopicACLToken,
+		Payload: ACLTokenEvent{SecretIDs: []string{"revoked-token"}},
+	}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := revoked.Next(ctx); !errors.Is(err, ErrSubscriptionClosed) {
+		t.Fatalf("Next on closed subscription: got %v, want ErrSubscriptionClosed", err)
+	}
+
+	e.Publish(2, []Event{{Topic: "Job", Key: "web", Index: 2}})
+	got := mustNext(t, unaffected)
+	if len(got) != 1 || got[0].Index != 2 {
+		t.Fatalf("unaffected subscription got %+v, want the index 2 event", got)
+	}
+}
@@ -5,20 +5,84 @@ import (
 	"sync"
 	"time"
 
+	"github.com/armon/go-metrics"
 	"github.com/hashicorp/go-hclog"
 )
 
+// defaultPruneTick is used when EventPublisherCfg.PruneTick is unset. It
+// was previously always zero (pruneTick was never populated from cfg),
+// which made periodicPrune spin in a tight time.After(0) loop.
+const defaultPruneTick = 5 * time.Second
+
 type EventPublisherCfg struct {
-	EventBufferSize int64
+	// TopicBufferSize bounds how many items each topic/key buffer retains,
+	// which in turn bounds how far a resuming subscriber (SubscribeRequest.Index)
+	// can fall behind before it's forced to reset.
+	TopicBufferSize int64
 	EventBufferTTL  time.Duration
+
+	// PruneTick is how often periodicPrune sweeps every topic buffer for
+	// items older than EventBufferTTL. Defaults to defaultPruneTick.
+	PruneTick time.Duration
+
+	// SnapshotHandlers maps each Topic that supports snapshot-on-subscribe
+	// to the function that materializes its current state. Topics without
+	// a handler skip straight to live buffer events on Subscribe.
+	SnapshotHandlers map[Topic]SnapshotFunc
+
+	// SnapCacheTTL bounds how long a built snapshot is reused by new
+	// subscribers before being rebuilt. Defaults to defaultSnapCacheTTL.
+	SnapCacheTTL time.Duration
+}
+
+// topicBuffer pairs the eventBuffer for a single subject with a count of
+// the subscriptions currently bound to it, so that buffers for subjects
+// nobody is watching anymore can be dropped instead of accumulating
+// forever.
+type topicBuffer struct {
+	mu       sync.Mutex
+	buf      *eventBuffer
+	refCount int64
+
+	// orphanedAt is set by releaseSubject when refCount drops to zero
+	// and cleared by getOrCreateBufferLocked if a new subscriber arrives
+	// before the buffer is reaped. It lets a client reconnecting shortly
+	// after a dropped connection resume from the same buffer instead of
+	// losing its history the instant it unsubscribes. Guarded by
+	// EventPublisher.mu, like refCount.
+	orphanedAt time.Time
 }
 
 type EventPublisher struct {
-	size int64
+	// mu guards the buffers map itself (adding/removing subjects).
+	// Appends and prunes of an individual buffer are synchronized by that
+	// buffer's own mutex instead, so that readers and writers of one
+	// subject never contend with another subject's.
+	mu sync.RWMutex
+
+	// buffers holds one eventBuffer per (Topic, Key) subject that has at
+	// least one active subscriber, or had one within the last bufferTTL
+	// (orphaned buffers are kept until reapOrphanedBuffers deletes them).
+	buffers map[topicSubject]*topicBuffer
+
+	bufferSize int64
+	bufferTTL  time.Duration
+
+	// snapMu guards the snapshots cache.
+	snapMu           sync.Mutex
+	snapshots        map[topicSubject]*eventSnapshot
+	snapshotHandlers map[Topic]SnapshotFunc
+	snapCacheTTL     time.Duration
 
-	lock sync.Mutex
+	// tokenMu guards subsByToken.
+	tokenMu     sync.Mutex
+	subsByToken map[string][]*Subscription
 
-	events *eventBuffer
+	// subsMu guards subs, the set of every active subscription regardless
+	// of whether it was made with a token, used to report per-subscription
+	// lag.
+	subsMu sync.Mutex
+	subs   map[*Subscription]struct{}
 
 	pruneTick time.Duration
 
@@ -34,10 +98,23 @@ func NewEventPublisher(ctx context.Context, cfg EventPublisherCfg) *EventPublish
 	if cfg.EventBufferTTL == 0 {
 		cfg.EventBufferTTL = 1 * time.Hour
 	}
-	buffer := newEventBuffer(cfg.EventBufferSize, cfg.EventBufferTTL)
+	if cfg.SnapCacheTTL == 0 {
+		cfg.SnapCacheTTL = defaultSnapCacheTTL
+	}
+	if cfg.PruneTick == 0 {
+		cfg.PruneTick = defaultPruneTick
+	}
 	e := &EventPublisher{
-		events:    buffer,
-		publishCh: make(chan changeEvents),
+		buffers:          make(map[topicSubject]*topicBuffer),
+		bufferSize:       cfg.TopicBufferSize,
+		bufferTTL:        cfg.EventBufferTTL,
+		snapshots:        make(map[topicSubject]*eventSnapshot),
+		snapshotHandlers: cfg.SnapshotHandlers,
+		snapCacheTTL:     cfg.SnapCacheTTL,
+		subsByToken:      make(map[string][]*Subscription),
+		subs:             make(map[*Subscription]struct{}),
+		pruneTick:        cfg.PruneTick,
+		publishCh:        make(chan changeEvents),
 	}
 
 	go e.handleUpdates(ctx)
@@ -53,6 +130,206 @@ func (e *EventPublisher) Publish(index uint64, events []Event) {
 	}
 }
 
+// Subscribe returns a new Subscription bound to each subject in the
+// request. A key of AllKeys is bound to the topic's AllKeys buffer, which
+// every event published on the topic is also appended to, so a wildcard
+// subscription sees the whole topic stream without reading from every
+// key's buffer individually.
+//
+// If a topic has a registered SnapshotFunc, the subscription starts at the
+// head of a materialized snapshot instead of the live buffer's head, and
+// transitions to live events once the snapshot has been fully replayed.
+//
+// If req.Index is non-zero, the subscription instead resumes directly from
+// the live buffer, skipping the snapshot: Subscription starts at the first
+// item with Index greater than req.Index. If that item has already been
+// evicted from the buffer, or the buffer has no history at all (e.g.
+// nobody has ever subscribed to the subject, or the last subscriber
+// unsubscribed and reapOrphanedBuffers has since reclaimed it), Subscribe
+// returns ErrSubscriptionResetRequired so the client knows it has to
+// start over instead of silently missing events. A buffer survives for
+// EventPublisherCfg.EventBufferTTL after its last subscriber leaves, so a
+// resume shortly after a dropped connection (a brief network blip, a
+// server-side reconnect) still succeeds.
+func (e *EventPublisher) Subscribe(req *SubscribeRequest) (*Subscription, error) {
+	heads := make(map[topicSubject]*bufferItem)
+	for topic, keys := range req.topics {
+		for _, key := range keys {
+			subject := topicSubject{Topic: topic, Key: key}
+
+			e.mu.Lock()
+			tb, created := e.getOrCreateBufferLocked(subject)
+			tb.refCount++
+			e.mu.Unlock()
+
+			if req.Index > 0 {
+				// A buffer we just created has no history at all, either
+				// because nobody has ever subscribed to this subject or
+				// because the last subscriber unsubscribed and
+				// reapOrphanedBuffers deleted it in the meantime. Either way
+				// there's nothing to resume from, so treat it the same as
+				// an evicted index rather than silently starting the
+				// subscription from the live head.
+				if created {
+					e.releaseSubjects(heads, subject)
+					return nil, ErrSubscriptionResetRequired
+				}
+
+				tb.mu.Lock()
+				item, err := tb.buf.StartAfter(req.Index)
+				tb.mu.Unlock()
+				if err != nil {
+					e.releaseSubjects(heads, subject)
+					return nil, ErrSubscriptionResetRequired
+				}
+				heads[subject] = item
+				continue
+			}
+
+			if _, ok := e.snapshotHandlers[topic]; ok {
+				snap := e.getOrBuildSnapshot(subject, req, tb)
+				heads[subject] = snap.buf.Head()
+				continue
+			}
+
+			heads[subject] = tb.buf.Head()
+		}
+	}
+
+	sub := newSubscription(req, heads, e.unsubscribe, e.incrDroppedReaders)
+
+	if req.Token != "" {
+		e.tokenMu.Lock()
+		e.subsByToken[req.Token] = append(e.subsByToken[req.Token], sub)
+		e.tokenMu.Unlock()
+	}
+
+	e.subsMu.Lock()
+	e.subs[sub] = struct{}{}
+	e.subsMu.Unlock()
+	metrics.IncrCounter([]string{"nomad", "event_publisher", "subscriptions"}, 1)
+
+	return sub, nil
+}
+
+// incrDroppedReaders is passed to newSubscription as its onDropped callback.
+func (e *EventPublisher) incrDroppedReaders() {
+	metrics.IncrCounter([]string{"nomad", "event_publisher", "dropped_readers"}, 1)
+}
+
+// getOrCreateBufferLocked returns the topicBuffer for subject, creating it
+// if this is the first subscriber to reference it, and reports whether it
+// had to create one. Callers must hold e.mu.
+func (e *EventPublisher) getOrCreateBufferLocked(subject topicSubject) (tb *topicBuffer, created bool) {
+	tb, ok := e.buffers[subject]
+	if ok {
+		tb.orphanedAt = time.Time{}
+		return tb, false
+	}
+	tb = &topicBuffer{buf: newEventBuffer(e.bufferSize, e.bufferTTL)}
+	e.buffers[subject] = tb
+	return tb, true
+}
+
+// unsubscribe drops sub's reference on each subject it was bound to,
+// marking any buffer that's no longer referenced by anyone as orphaned
+// (see releaseSubject), and removes sub from the token index if it was
+// subscribed with one.
+func (e *EventPublisher) unsubscribe(sub *Subscription) {
+	for _, subject := range sub.subjects {
+		e.releaseSubject(subject)
+	}
+
+	e.subsMu.Lock()
+	delete(e.subs, sub)
+	e.subsMu.Unlock()
+
+	if sub.req.Token == "" {
+		return
+	}
+
+	e.tokenMu.Lock()
+	defer e.tokenMu.Unlock()
+
+	subs := e.subsByToken[sub.req.Token]
+	for i, s := range subs {
+		if s == sub {
+			e.subsByToken[sub.req.Token] = append(subs[:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(e.subsByToken[sub.req.Token]) == 0 {
+		delete(e.subsByToken, sub.req.Token)
+	}
+}
+
+// releaseSubjects releases every subject already acquired in heads plus
+// current. It's used to unwind the reference Subscribe took on each
+// subject's buffer when a later subject in the same request fails
+// part-way through, so the earlier ones don't leak a refCount with no
+// Subscription left around to eventually call Unsubscribe and release it.
+func (e *EventPublisher) releaseSubjects(heads map[topicSubject]*bufferItem, current topicSubject) {
+	for subject := range heads {
+		e.releaseSubject(subject)
+	}
+	e.releaseSubject(current)
+}
+
+// releaseSubject drops a single reference on subject's buffer. Once
+// nothing references it anymore it's marked orphaned rather than deleted
+// outright, so it survives reapOrphanedBuffers' next sweep: that gives a
+// lone subscriber reconnecting after a brief blip a window in which to
+// resume from the same buffer instead of immediately getting
+// ErrSubscriptionResetRequired.
+func (e *EventPublisher) releaseSubject(subject topicSubject) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	tb, ok := e.buffers[subject]
+	if !ok {
+		return
+	}
+	tb.refCount--
+	if tb.refCount <= 0 {
+		tb.orphanedAt = time.Now()
+	}
+}
+
+// reapOrphanedBuffers deletes every buffer that's had no subscribers for
+// longer than bufferTTL, the same horizon individual items are pruned at.
+// It's called once per pruneTick from periodicPrune.
+func (e *EventPublisher) reapOrphanedBuffers() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for subject, tb := range e.buffers {
+		if tb.refCount > 0 || tb.orphanedAt.IsZero() {
+			continue
+		}
+		if time.Since(tb.orphanedAt) > e.bufferTTL {
+			delete(e.buffers, subject)
+		}
+	}
+}
+
+// CloseSubscriptionsForTokens force-closes every active subscription made
+// with one of the given token secret IDs, causing their outstanding and
+// future Next calls to return ErrSubscriptionClosed. It's called
+// automatically when a TopicACLToken event is published, so that an ACL
+// policy/role/token change invalidates any subscription relying on the
+// old permissions rather than silently continuing to deliver to it.
+func (e *EventPublisher) CloseSubscriptionsForTokens(secretIDs []string) {
+	e.tokenMu.Lock()
+	defer e.tokenMu.Unlock()
+
+	for _, id := range secretIDs {
+		for _, sub := range e.subsByToken[id] {
+			sub.forceClose()
+		}
+		delete(e.subsByToken, id)
+	}
+}
+
 func (e *EventPublisher) handleUpdates(ctx context.Context) {
 	for {
 		select {
@@ -66,15 +343,69 @@ func (e *EventPublisher) handleUpdates(ctx context.Context) {
 	}
 }
 
+// periodicPrune evicts expired items from every topic buffer every
+// pruneTick, and reports buffer health metrics (via armon/go-metrics, as
+// used elsewhere in Nomad): buffer length per topic, items pruned per
+// tick, subscriber count per buffer, and per-subscription lag. Lag is
+// reported per topic rather than per subscription, since a subscription
+// has no stable, low-cardinality label to report it under: it's the
+// worst (highest) lag of any subscription bound to that topic's buffers.
 func (e *EventPublisher) periodicPrune(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-time.After(e.pruneTick):
-			e.lock.Lock()
-			e.events.prune()
-			e.lock.Unlock()
+			e.reapOrphanedBuffers()
+
+			e.mu.RLock()
+			buffers := make(map[topicSubject]*topicBuffer, len(e.buffers))
+			refCounts := make(map[topicSubject]int64, len(e.buffers))
+			for subject, tb := range e.buffers {
+				buffers[subject] = tb
+				refCounts[subject] = tb.refCount
+			}
+			e.subsMu.Lock()
+			subs := make([]*Subscription, 0, len(e.subs))
+			for sub := range e.subs {
+				subs = append(subs, sub)
+			}
+			e.subsMu.Unlock()
+			e.mu.RUnlock()
+
+			tailIndex := make(map[topicSubject]uint64, len(buffers))
+			for subject, tb := range buffers {
+				tb.mu.Lock()
+				pruned := tb.buf.prune()
+				length := tb.buf.Len()
+				refCount := refCounts[subject]
+				tailIndex[subject] = tb.buf.Tail().Index
+				tb.mu.Unlock()
+
+				labels := []metrics.Label{{Name: "topic", Value: string(subject.Topic)}}
+				metrics.SetGaugeWithLabels([]string{"nomad", "event_publisher", "buffer_len"}, float32(length), labels)
+				metrics.SetGaugeWithLabels([]string{"nomad", "event_publisher", "subscribers"}, float32(refCount), labels)
+				if pruned > 0 {
+					metrics.IncrCounterWithLabels([]string{"nomad", "event_publisher", "pruned"}, float32(pruned), labels)
+				}
+			}
+
+			lagByTopic := make(map[Topic]uint64, len(buffers))
+			for _, sub := range subs {
+				for _, subject := range sub.subjects {
+					tail, ok := tailIndex[subject]
+					if !ok {
+						continue
+					}
+					if l := sub.Lag(tail); l > lagByTopic[subject.Topic] {
+						lagByTopic[subject.Topic] = l
+					}
+				}
+			}
+			for topic, lag := range lagByTopic {
+				labels := []metrics.Label{{Name: "topic", Value: string(topic)}}
+				metrics.SetGaugeWithLabels([]string{"nomad", "event_publisher", "subscription_lag"}, float32(lag), labels)
+			}
 		}
 	}
 }
@@ -84,11 +415,40 @@ type changeEvents struct {
 	events []Event
 }
 
-// sendEvents sends the given events to any applicable topic listeners, as well
-// as any ACL update events to cause affected listeners to reset their stream.
+// sendEvents routes each event to the buffer for its own subject, as well
+// as to its topic's AllKeys buffer for wildcard subscribers. Events for a
+// subject with no buffer (never subscribed to, or already reaped by
+// reapOrphanedBuffers) are dropped rather than buffered. It
+// also watches for TopicACLToken events and invalidates any subscription
+// for the tokens they name.
 func (e *EventPublisher) sendEvents(update changeEvents) {
-	e.lock.Lock()
-	defer e.lock.Unlock()
+	bySubject := make(map[topicSubject][]Event)
+	for _, event := range update.events {
+		if event.Topic == TopicACLToken {
+			if tokenEvent, ok := event.Payload.(ACLTokenEvent); ok {
+				e.CloseSubscriptionsForTokens(tokenEvent.SecretIDs)
+			}
+		}
+
+		s := subject(event)
+		bySubject[s] = append(bySubject[s], event)
 
-	e.events.Append(update.index, update.events)
+		if s.Key != AllKeys {
+			wildcard := topicSubject{Topic: s.Topic, Key: AllKeys}
+			bySubject[wildcard] = append(bySubject[wildcard], event)
+		}
+	}
+
+	for s, events := range bySubject {
+		e.mu.RLock()
+		tb, ok := e.buffers[s]
+		e.mu.RUnlock()
+		if !ok {
+			continue
+		}
+
+		tb.mu.Lock()
+		tb.buf.Append(update.index, events)
+		tb.mu.Unlock()
+	}
 }
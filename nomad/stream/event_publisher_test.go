@@ -0,0 +1,88 @@
+package stream
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// newTestPublisher returns an EventPublisher torn down when the test ends.
+func newTestPublisher(t *testing.T, cfg EventPublisherCfg) *EventPublisher {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return NewEventPublisher(ctx, cfg)
+}
+
+// mustNext calls sub.Next with a generous timeout and fails the test
+// instead of hanging forever if no event shows up.
+func mustNext(t *testing.T, sub *Subscription) []Event {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	events, err := sub.Next(ctx)
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	return events
+}
+
+func TestEventPublisher_SubscribeRoutesPerSubject(t *testing.T) {
+	e := newTestPublisher(t, EventPublisherCfg{TopicBufferSize: 16})
+
+	webSub, err := e.Subscribe(NewSubscribeRequest(map[Topic][]string{"Job": {"web"}}))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer webSub.Unsubscribe()
+
+	allSub, err := e.Subscribe(NewSubscribeRequest(map[Topic][]string{"Job": {AllKeys}}))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer allSub.Unsubscribe()
+
+	e.Publish(1, []Event{{Topic: "Job", Key: "web", Index: 1}})
+	e.Publish(2, []Event{{Topic: "Job", Key: "db", Index: 2}})
+
+	// A subscriber bound to a single key only sees events routed to that
+	// key's own buffer, not ones published under a different key.
+	got := mustNext(t, webSub)
+	if len(got) != 1 || got[0].Key != "web" {
+		t.Fatalf("webSub got %+v, want exactly the web key event", got)
+	}
+
+	// A wildcard (AllKeys) subscriber sees every key's events, since each
+	// is also appended to the topic's AllKeys buffer.
+	first := mustNext(t, allSub)
+	second := mustNext(t, allSub)
+	if len(first) != 1 || first[0].Key != "web" || len(second) != 1 || second[0].Key != "db" {
+		t.Fatalf("allSub got %+v then %+v, want web then db", first, second)
+	}
+}
+
+func TestEventPublisher_SubscriptionFansInAcrossSubjects(t *testing.T) {
+	e := newTestPublisher(t, EventPublisherCfg{TopicBufferSize: 16})
+
+	// A single subscription spanning two keys is served by a forward
+	// goroutine per key, fanned into one updateCh; it should see events
+	// published under either key without having to read each separately.
+	sub, err := e.Subscribe(NewSubscribeRequest(map[Topic][]string{"Job": {"web", "db"}}))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	e.Publish(1, []Event{{Topic: "Job", Key: "web", Index: 1}})
+	e.Publish(2, []Event{{Topic: "Job", Key: "db", Index: 2}})
+
+	seen := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		for _, ev := range mustNext(t, sub) {
+			seen[ev.Key] = true
+		}
+	}
+	if !seen["web"] || !seen["db"] {
+		t.Fatalf("subscription spanning two keys saw %v, want both web and db", seen)
+	}
+}
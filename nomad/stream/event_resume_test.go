@@ -0,0 +1,98 @@
+package stream
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestEventPublisher_SubscribeResumeEvictedIndex exercises StartAfter's
+// three outcomes for a resuming subscriber once eviction has happened:
+// an index older than the oldest retained item resets, the boundary
+// index exactly at the eviction point resumes cleanly, and an index still
+// in the buffer resumes from the right place.
+func TestEventPublisher_SubscribeResumeEvictedIndex(t *testing.T) {
+	e := newTestPublisher(t, EventPublisherCfg{TopicBufferSize: 2})
+
+	// A live subscriber is required to keep the buffer around at all:
+	// sendEvents drops events for subjects nobody has subscribed to.
+	keep, err := e.Subscribe(NewSubscribeRequest(map[Topic][]string{"Job": {"evict"}}))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer keep.Unsubscribe()
+
+	for i := uint64(1); i <= 5; i++ {
+		e.Publish(i, []Event{{Topic: "Job", Key: "evict", Index: i}})
+		mustNext(t, keep)
+	}
+	// TopicBufferSize=2 evicts as each new item pushes the buffer past its
+	// max size. The first eviction drops the empty sentinel, which isn't
+	// recorded, so by now indexes 1 and 2 have been dropped (droppedIdx==2)
+	// and 3 (the head), 4 and 5 remain.
+
+	t.Run("older than dropped resets", func(t *testing.T) {
+		_, err := e.Subscribe(&SubscribeRequest{topics: map[Topic][]string{"Job": {"evict"}}, Index: 1})
+		if !errors.Is(err, ErrSubscriptionResetRequired) {
+			t.Fatalf("Subscribe with Index=1: got err %v, want ErrSubscriptionResetRequired", err)
+		}
+	})
+
+	t.Run("exactly at dropped boundary resumes", func(t *testing.T) {
+		sub, err := e.Subscribe(&SubscribeRequest{topics: map[Topic][]string{"Job": {"evict"}}, Index: 2})
+		if err != nil {
+			t.Fatalf("Subscribe with Index=2: %v", err)
+		}
+		defer sub.Unsubscribe()
+
+		got := mustNext(t, sub)
+		if len(got) != 1 || got[0].Index != 3 {
+			t.Fatalf("Subscribe with Index=2 first delivered %+v, want index 3", got)
+		}
+	})
+
+	t.Run("still in buffer resumes from the right place", func(t *testing.T) {
+		sub, err := e.Subscribe(&SubscribeRequest{topics: map[Topic][]string{"Job": {"evict"}}, Index: 3})
+		if err != nil {
+			t.Fatalf("Subscribe with Index=3: %v", err)
+		}
+		defer sub.Unsubscribe()
+
+		got := mustNext(t, sub)
+		if len(got) != 1 || got[0].Index != 4 {
+			t.Fatalf("Subscribe with Index=3 first delivered %+v, want index 4", got)
+		}
+	})
+}
+
+// TestEventPublisher_SubscribeResumeAfterLastUnsubscribe verifies that a
+// buffer survives long enough (EventBufferTTL) for a client reconnecting
+// shortly after dropping its only subscription to resume, rather than
+// immediately getting ErrSubscriptionResetRequired from a freshly recreated
+// buffer with no history.
+func TestEventPublisher_SubscribeResumeAfterLastUnsubscribe(t *testing.T) {
+	e := newTestPublisher(t, EventPublisherCfg{TopicBufferSize: 16})
+
+	sub, err := e.Subscribe(NewSubscribeRequest(map[Topic][]string{"Job": {"reconnect"}}))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	e.Publish(1, []Event{{Topic: "Job", Key: "reconnect", Index: 1}})
+	mustNext(t, sub)
+	sub.Unsubscribe()
+
+	// The buffer is now orphaned but not yet reaped (reapOrphanedBuffers
+	// only runs on a timer and e.bufferTTL defaults to an hour), so a
+	// resume from the index already delivered should succeed immediately.
+	resumed, err := e.Subscribe(&SubscribeRequest{topics: map[Topic][]string{"Job": {"reconnect"}}, Index: 1})
+	if err != nil {
+		t.Fatalf("Subscribe to resume after unsubscribe: %v", err)
+	}
+	defer resumed.Unsubscribe()
+
+	e.Publish(2, []Event{{Topic: "Job", Key: "reconnect", Index: 2}})
+	got := mustNext(t, resumed)
+	if len(got) != 1 || got[0].Index != 2 {
+		t.Fatalf("resumed subscription delivered %+v, want index 2", got)
+	}
+}
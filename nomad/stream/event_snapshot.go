@@ -0,0 +1,146 @@
+package stream
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// errNoSnapshotHandler is delivered to a subscriber if a snapshot is
+// requested for a topic that has no registered SnapshotFunc. Callers
+// should not hit this in practice since Subscribe only builds a snapshot
+// for topics it knows have a handler.
+var errNoSnapshotHandler = errors.New("stream: no snapshot handler registered for topic")
+
+// defaultSnapCacheTTL is used when EventPublisherCfg.SnapCacheTTL is unset.
+// It only needs to be long enough to cover a burst of subscribers arriving
+// together (e.g. after a leader election), not to serve as a general
+// purpose cache.
+const defaultSnapCacheTTL = 3 * time.Second
+
+// unboundedBufferSize is used for a snapshot's own buffer instead of
+// EventPublisher's TopicBufferSize. TopicBufferSize bounds the live
+// buffer's trailing history, but a materialized snapshot (e.g. one item
+// per current Allocation) routinely holds far more items than that, and
+// eventBuffer's maxSize==0 means "evict immediately", not "unlimited", so
+// it can't be left at the zero value either. Using TopicBufferSize here
+// would make appendItem evict the oldest snapshot items as it built,
+// silently truncating the initial state delivered to new subscribers.
+const unboundedBufferSize = math.MaxInt64
+
+// SnapshotFunc materializes the current state for req's topic into buf,
+// appending one or more events that bring a new subscriber up to date
+// before it transitions to live events. It should call buf.AppendErr if it
+// can't complete the snapshot so subscribers waiting on it get a clear
+// error instead of blocking forever.
+type SnapshotFunc func(req *SubscribeRequest, buf *eventBuffer)
+
+// eventSnapshot is a buffer populated once by a topic's SnapshotFunc and
+// shared by every subscription that arrives while it's still fresh. Once
+// built, its tail is spliced onto the live topic buffer (via NextLink) so
+// a subscriber reading from the snapshot transitions into live events with
+// no gap and no duplicate delivery.
+type eventSnapshot struct {
+	buf *eventBuffer
+
+	// createdAt is set once the snapshot has finished building, and used
+	// to expire it from the cache after SnapCacheTTL.
+	createdAt time.Time
+
+	// done is closed once the snapshot has finished building, successfully
+	// or not, so that subscribers who found it already in progress can
+	// wait on it instead of starting their own build.
+	done chan struct{}
+}
+
+// expired reports whether the snapshot is older than ttl and should no
+// longer be handed to new subscribers.
+func (s *eventSnapshot) expired(ttl time.Duration) bool {
+	select {
+	case <-s.done:
+	default:
+		// Still building; not expired yet.
+		return false
+	}
+	return time.Since(s.createdAt) > ttl
+}
+
+// getOrBuildSnapshot returns the cached snapshot for subject if one is
+// present and not expired, building and caching a new one otherwise. live
+// is the topicBuffer the snapshot will be spliced onto so subscribers can
+// seamlessly transition from snapshot to live events.
+func (e *EventPublisher) getOrBuildSnapshot(subject topicSubject, req *SubscribeRequest, live *topicBuffer) *eventSnapshot {
+	e.snapMu.Lock()
+	if snap, ok := e.snapshots[subject]; ok && !snap.expired(e.snapCacheTTL) {
+		e.snapMu.Unlock()
+		<-snap.done
+		return snap
+	}
+
+	snap := &eventSnapshot{
+		buf:  newEventBuffer(unboundedBufferSize, e.bufferTTL),
+		done: make(chan struct{}),
+	}
+	e.snapshots[subject] = snap
+	e.snapMu.Unlock()
+
+	e.buildSnapshot(subject, req, snap, live)
+	return snap
+}
+
+// buildSnapshot runs the registered SnapshotFunc for subject's topic,
+// splices the resulting buffer onto the live topic buffer, and marks the
+// snapshot done so any subscribers waiting on it can proceed.
+func (e *EventPublisher) buildSnapshot(subject topicSubject, req *SubscribeRequest, snap *eventSnapshot, live *topicBuffer) {
+	defer close(snap.done)
+
+	fn, ok := e.snapshotHandlers[subject.Topic]
+	if !ok {
+		snap.buf.AppendErr(errNoSnapshotHandler)
+		snap.createdAt = time.Now()
+		return
+	}
+
+	// Anchor on the live tail as it was *before* fn runs, not after:
+	// otherwise any event appended to the live buffer while the snapshot
+	// was building would fall between the two and never reach a
+	// subscriber that reads through the snapshot.
+	live.mu.Lock()
+	liveAnchor := live.buf.Tail()
+	live.mu.Unlock()
+
+	fn(req, snap.buf)
+
+	// The snapshot may already reflect state as of a later index than
+	// liveAnchor (anything the FSM published while fn was reading state).
+	// Replay the live buffer from the anchor, appending only what the
+	// snapshot doesn't already cover, so the transition has neither a gap
+	// nor a duplicate at the snapshot's index.
+	snapIndex := snap.buf.Tail().Index
+	item := liveAnchor
+	for {
+		next := item.NextNoBlock()
+		if next == nil {
+			break
+		}
+		item = next
+		if item.Events == nil || item.Index <= snapIndex {
+			continue
+		}
+		snap.buf.Append(item.Index, item.Events)
+	}
+
+	// Splice the snapshot's tail onto item, the last item the replay
+	// loop above reached (lock-free, so it may itself already be the
+	// live tail, or may have advanced further by the time we get here).
+	// Re-reading live.buf.Tail() instead, after releasing live.mu,
+	// would reopen the same gap this replay is meant to close: anything
+	// appended between the loop ending and that re-read would be
+	// skipped. NextLink shares item's link rather than copying it, so
+	// once the live buffer appends past this point both the original
+	// live readers and our spliced-in snapshot readers are woken by the
+	// same close(ch).
+	snap.buf.AppendBuffer(item.NextLink())
+
+	snap.createdAt = time.Now()
+}
@@ -0,0 +1,61 @@
+package stream
+
+import "testing"
+
+// TestEventPublisher_SnapshotTransitionNoGapOrDuplicate subscribes to a
+// topic with a SnapshotFunc that publishes a live event of its own partway
+// through building the snapshot, mimicking state changing while a snapshot
+// reads it. The subscriber should see every snapshot item followed by every
+// live item exactly once, with neither a gap nor a duplicate at the point
+// the two are spliced together.
+func TestEventPublisher_SnapshotTransitionNoGapOrDuplicate(t *testing.T) {
+	const topic Topic = "Job"
+
+	// e is referenced from inside its own SnapshotHandlers closure below, so
+	// it must be declared before the call that constructs it.
+	var e *EventPublisher
+	e = newTestPublisher(t, EventPublisherCfg{
+		TopicBufferSize: 16,
+		SnapshotHandlers: map[Topic]SnapshotFunc{
+			topic: func(req *SubscribeRequest, buf *eventBuffer) {
+				buf.Append(1, []Event{{Topic: topic, Key: "web", Index: 1}})
+
+				// Simulate an event committed to the live buffer while the
+				// snapshot is still being built from a separate FSM read.
+				e.Publish(2, []Event{{Topic: topic, Key: "web", Index: 2}})
+
+				buf.Append(3, []Event{{Topic: topic, Key: "web", Index: 3}})
+			},
+		},
+	})
+
+	sub, err := e.Subscribe(NewSubscribeRequest(map[Topic][]string{topic: {"web"}}))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	e.Publish(4, []Event{{Topic: topic, Key: "web", Index: 4}})
+
+	var got []uint64
+	for len(got) < 4 {
+		for _, ev := range mustNext(t, sub) {
+			got = append(got, ev.Index)
+		}
+	}
+
+	// The snapshot itself only ever contains 1 and 3: event 2 is published
+	// through the live buffer while fn is still running, and buildSnapshot's
+	// replay step recognizes its index is already covered by the snapshot
+	// (<=snapIndex) and skips re-appending it, so it's delivered once, from
+	// the live buffer, after the snapshot's own items.
+	want := []uint64{1, 3, 2, 4}
+	if len(got) != len(want) {
+		t.Fatalf("got indexes %v, want %v", got, want)
+	}
+	for i, idx := range want {
+		if got[i] != idx {
+			t.Fatalf("got indexes %v, want %v", got, want)
+		}
+	}
+}
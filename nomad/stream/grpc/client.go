@@ -0,0 +1,99 @@
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// reconnectDelay is how long Client waits between a dropped stream and its
+// next Subscribe attempt.
+const reconnectDelay = 1 * time.Second
+
+// ClientStream is the subset of the generated EventStream_SubscribeClient
+// interface Client needs. Declared locally for the same reason as
+// ServerStream.
+type ClientStream interface {
+	Recv() (*EventStreamResponse, error)
+}
+
+// Dialer opens a new ClientStream for req. The real implementation wraps
+// EventStreamClient.Subscribe on a grpc.ClientConn; it's a function here
+// so Client doesn't need to depend on connection setup directly.
+type Dialer func(ctx context.Context, req *SubscribeRequest) (ClientStream, error)
+
+// Client consumes an EventStream Subscribe RPC, transparently reconnecting
+// and resuming from the last Index it saw whenever the stream ends or the
+// server sends a Reset frame, so downstream consumers (UI, autoscaler, log
+// shippers) can treat it as a single continuous event stream.
+type Client struct {
+	dial   Dialer
+	topics map[string]*Keys
+	token  string
+
+	logger hclog.Logger
+}
+
+// NewClient returns a Client that subscribes to topics using dial,
+// authenticating with token.
+func NewClient(dial Dialer, topics map[string]*Keys, token string, logger hclog.Logger) *Client {
+	return &Client{dial: dial, topics: topics, token: token, logger: logger}
+}
+
+// Run streams events to handle until ctx is cancelled, reconnecting with a
+// fixed backoff on any stream error. A Reset frame clears the resume
+// Index, so the next reconnect starts over (typically picking up a fresh
+// server-side snapshot); any other disconnect resumes from the last Index
+// received.
+func (c *Client) Run(ctx context.Context, handle func(*Event)) error {
+	var index uint64
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		req := &SubscribeRequest{Topics: c.topics, Index: index, Token: c.token}
+		respStream, err := c.dial(ctx, req)
+		if err != nil {
+			c.logger.Warn("subscribe failed, retrying", "error", err)
+			if !sleepCtx(ctx, reconnectDelay) {
+				return ctx.Err()
+			}
+			continue
+		}
+
+		for {
+			resp, err := respStream.Recv()
+			if err != nil {
+				c.logger.Warn("event stream ended, reconnecting", "error", err)
+				break
+			}
+
+			switch {
+			case resp.Reset != nil:
+				c.logger.Info("server requested subscription reset", "reason", resp.Reset.Reason)
+				index = 0
+			case resp.Event != nil:
+				index = resp.Event.Index
+				handle(resp.Event)
+			}
+		}
+
+		if !sleepCtx(ctx, reconnectDelay) {
+			return ctx.Err()
+		}
+	}
+}
+
+// sleepCtx waits for d or ctx cancellation, returning false if ctx was
+// cancelled first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,102 @@
+package grpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/nomad/nomad/stream"
+)
+
+// Codec (de)serializes a single topic's Payload to and from the bytes
+// carried in Event.Payload on the wire.
+type Codec interface {
+	Encode(payload interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+// Registry maps each topic Nomad streams externally to the Codec that
+// (de)serializes its payload. It's kept separate from stream.EventPublisher
+// so the core pubsub package doesn't need to know about any concrete
+// Nomad message type. See DefaultRegistry for the Codecs registered for
+// the well-known topics (Allocation, Deployment, Node, and Job).
+type Registry struct {
+	mu     sync.RWMutex
+	codecs map[stream.Topic]Codec
+}
+
+// NewRegistry returns an empty Registry ready to have Codecs registered
+// with Register.
+func NewRegistry() *Registry {
+	return &Registry{codecs: make(map[stream.Topic]Codec)}
+}
+
+// Register associates codec with topic, overwriting any previous
+// registration.
+func (r *Registry) Register(topic stream.Topic, codec Codec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.codecs[topic] = codec
+}
+
+// Encode serializes payload using the Codec registered for topic.
+func (r *Registry) Encode(topic stream.Topic, payload interface{}) ([]byte, error) {
+	codec, ok := r.get(topic)
+	if !ok {
+		return nil, fmt.Errorf("grpc: no codec registered for topic %q", topic)
+	}
+	return codec.Encode(payload)
+}
+
+// Decode deserializes data using the Codec registered for topic.
+func (r *Registry) Decode(topic stream.Topic, data []byte) (interface{}, error) {
+	codec, ok := r.get(topic)
+	if !ok {
+		return nil, fmt.Errorf("grpc: no codec registered for topic %q", topic)
+	}
+	return codec.Decode(data)
+}
+
+func (r *Registry) get(topic stream.Topic) (Codec, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	codec, ok := r.codecs[topic]
+	return codec, ok
+}
+
+// JSONCodec (de)serializes a payload with encoding/json. It's a stand-in
+// for per-topic protobuf Codecs; event.proto carries the payload as opaque
+// bytes and defines no message types for the topics themselves.
+// Decode has no concrete Go type to unmarshal into (Codec doesn't carry
+// one), so it returns a map[string]interface{} rather than, say, a
+// *structs.Allocation.
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(payload interface{}) ([]byte, error) {
+	return json.Marshal(payload)
+}
+
+func (JSONCodec) Decode(data []byte) (interface{}, error) {
+	var payload map[string]interface{}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// DefaultRegistry returns a Registry with a JSONCodec registered for
+// every topic Nomad streams externally. Callers that generate real
+// protobuf Codecs for these topics should register those instead of
+// calling this constructor.
+func DefaultRegistry() *Registry {
+	r := NewRegistry()
+	for _, topic := range []stream.Topic{
+		stream.TopicAllocation,
+		stream.TopicDeployment,
+		stream.TopicNode,
+		stream.TopicJob,
+	} {
+		r.Register(topic, JSONCodec{})
+	}
+	return r
+}
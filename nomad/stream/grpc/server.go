@@ -0,0 +1,98 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hashicorp/nomad/nomad/event"
+	"github.com/hashicorp/nomad/nomad/stream"
+)
+
+// ServerStream is the subset of the generated EventStream_SubscribeServer
+// interface (which embeds grpc.ServerStream) that Server.Subscribe needs.
+// It's declared locally, rather than imported from event.pb.go, for the
+// same reason as the types in types.go.
+type ServerStream interface {
+	Context() context.Context
+	Send(*EventStreamResponse) error
+}
+
+// Server adapts a stream.EventPublisher to the EventStream gRPC service
+// defined in event.proto.
+type Server struct {
+	publisher *stream.EventPublisher
+	codecs    *Registry
+}
+
+// NewServer returns a Server that streams events from publisher, encoding
+// payloads with the Codecs registered in codecs.
+func NewServer(publisher *stream.EventPublisher, codecs *Registry) *Server {
+	return &Server{publisher: publisher, codecs: codecs}
+}
+
+// Subscribe implements the server side of EventStream.Subscribe: it
+// translates req into a stream.SubscribeRequest, pumps Subscription.Next
+// into respStream.Send, and turns a closed, reset-required, or dropped-item
+// subscription into a Reset frame instead of an RPC error, so the client
+// can tell it needs to resubscribe rather than treating the stream ending
+// as fatal.
+func (s *Server) Subscribe(req *SubscribeRequest, respStream ServerStream) error {
+	subReq := toSubscribeRequest(req)
+
+	sub, err := s.publisher.Subscribe(subReq)
+	if err != nil {
+		if errors.Is(err, stream.ErrSubscriptionResetRequired) {
+			return respStream.Send(&EventStreamResponse{Reset: &ResetFrame{Reason: err.Error()}})
+		}
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	ctx := respStream.Context()
+	for {
+		events, err := sub.Next(ctx)
+		switch {
+		case errors.Is(err, stream.ErrSubscriptionClosed):
+			return respStream.Send(&EventStreamResponse{Reset: &ResetFrame{Reason: err.Error()}})
+		case errors.Is(err, event.ErrItemDropped):
+			// The subscription fell behind and lost an item out of a
+			// topic buffer before it got to it. The client can't catch up
+			// incrementally, so tell it to resubscribe instead of failing
+			// the RPC as a transport error.
+			return respStream.Send(&EventStreamResponse{Reset: &ResetFrame{Reason: err.Error()}})
+		case err != nil:
+			return err
+		}
+
+		for _, event := range events {
+			payload, err := s.codecs.Encode(event.Topic, event.Payload)
+			if err != nil {
+				return err
+			}
+
+			wire := &Event{
+				Topic:   string(event.Topic),
+				Key:     event.Key,
+				Index:   event.Index,
+				Payload: payload,
+			}
+			if err := respStream.Send(&EventStreamResponse{Event: wire}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// toSubscribeRequest translates the wire SubscribeRequest into the
+// stream.SubscribeRequest the publisher understands.
+func toSubscribeRequest(req *SubscribeRequest) *stream.SubscribeRequest {
+	topics := make(map[stream.Topic][]string, len(req.Topics))
+	for topic, keys := range req.Topics {
+		topics[stream.Topic(topic)] = keys.Keys
+	}
+
+	subReq := stream.NewSubscribeRequest(topics)
+	subReq.Index = req.Index
+	subReq.Token = req.Token
+	return subReq
+}
@@ -0,0 +1,43 @@
+// Package grpc exposes stream.EventPublisher's Subscribe over gRPC so that
+// external clients (UI, autoscaler, log shippers) can consume Nomad state
+// changes as a push stream instead of polling blocking queries.
+//
+// The message types below mirror event.proto. They're hand-written rather
+// than generated by protoc because this environment doesn't have the
+// protobuf toolchain available. They are not identical to protoc's output:
+// the reset message is ResetFrame here (Reset in event.proto), and the
+// EventStreamResponse oneof is two plain pointer fields.
+package grpc
+
+// SubscribeRequest is the wire form of stream.SubscribeRequest.
+type SubscribeRequest struct {
+	Topics map[string]*Keys
+	Index  uint64
+	Token  string
+}
+
+// Keys is the wire form of one topic's requested keys.
+type Keys struct {
+	Keys []string
+}
+
+// Event is the wire form of stream.Event, with Payload already encoded by
+// the Codec registered for its Topic.
+type Event struct {
+	Topic   string
+	Key     string
+	Index   uint64
+	Payload []byte
+}
+
+// ResetFrame is the wire form of a server-initiated subscription reset.
+type ResetFrame struct {
+	Reason string
+}
+
+// EventStreamResponse is one frame of the Subscribe stream: exactly one of
+// Event or Reset is set.
+type EventStreamResponse struct {
+	Event *Event
+	Reset *ResetFrame
+}
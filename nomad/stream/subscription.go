@@ -3,6 +3,7 @@ package stream
 import (
 	"context"
 	"errors"
+	"sync"
 	"sync/atomic"
 
 	"github.com/hashicorp/go-hclog"
@@ -23,77 +24,208 @@ const (
 // closed. The client should Unsubscribe, then re-Subscribe.
 var ErrSubscriptionClosed = errors.New("subscription closed by server, client should resubscribe")
 
+// ErrSubscriptionResetRequired is returned by Subscribe when a client asked
+// to resume from an Index that has already been evicted from the relevant
+// topic buffer. The client can't catch up incrementally and should
+// re-subscribe from scratch (picking up a fresh snapshot where available).
+var ErrSubscriptionResetRequired = errors.New("subscription reset required, requested index no longer in buffer")
+
 type Subscriber struct {
 	logger hclog.Logger
 }
 
+// Subscription delivers the merged stream of events for every subject a
+// SubscribeRequest asked for. Each subject is read by its own forward
+// goroutine, which fans its events in onto updateCh, so a subscription
+// spanning several topics/keys isn't limited by the slowest one.
 type Subscription struct {
 	// state is accessed atomically 0 means open, 1 means closed with reload
 	state uint32
 
 	req *SubscribeRequest
 
-	// currentItem stores the current buffer item we are on. It
-	// is mutated by calls to Next.
-	currentItem *bufferItem
+	subjects []topicSubject
+
+	// updateCh is the fan-in point every forward goroutine delivers to. It
+	// is sized to the number of subjects so a fast subject can't block on
+	// a slow one being drained.
+	updateCh chan subscriptionUpdate
+
+	// ctx/cancel bound the lifetime of the forward goroutines. It is
+	// distinct from the ctx passed to Next, which only bounds a single call.
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	// forceClosed is closed when forceClose is called. It is used by
 	// EventPublisher to cancel Next().
 	forceClosed chan struct{}
+
+	// lastIndex is the Index of the most recent item delivered to
+	// updateCh, accessed atomically. EventPublisher reads it to report
+	// per-subscription lag (a topic buffer's tail Index minus this).
+	lastIndex uint64
+
+	unsubscribeOnce sync.Once
+	unsubscribeFn   func(sub *Subscription)
+	onDropped       func()
 }
 
-type SubscribeRequest struct {
-	// topics []Topic
+type subscriptionUpdate struct {
+	events []Event
+	err    error
+}
 
+type SubscribeRequest struct {
+	// topics maps each requested Topic to the Keys within it being
+	// subscribed to. A Key of AllKeys subscribes to every key on that
+	// Topic.
 	topics map[Topic][]string
+
+	// Index lets a reconnecting client resume a subscription where it left
+	// off instead of starting over from a snapshot. Zero means start from
+	// the current head of the buffer (or a snapshot, if the topic has one).
+	Index uint64
+
+	// Token is the ACL token secret ID the subscription was made with.
+	// EventPublisher tracks subscriptions by Token so that a policy/role/
+	// token change event can force all of a token's subscriptions closed.
+	Token string
+
+	// Authorizer is consulted by Subscription.Next to drop events whose
+	// Payload implements ACLPayload and denies it read access. A nil
+	// Authorizer disables ACL filtering entirely.
+	Authorizer Authorizer
+}
+
+// NewSubscribeRequest constructs a SubscribeRequest for the given topics,
+// for callers outside this package (e.g. stream/grpc) that can't set the
+// unexported topics field directly.
+func NewSubscribeRequest(topics map[Topic][]string) *SubscribeRequest {
+	return &SubscribeRequest{topics: topics}
 }
 
-// type Topic struct {
-// 	Type string
-// 	Keys []string
-// }
+// newSubscription constructs a Subscription bound to the given heads (one
+// per requested subject) and starts a forward goroutine per subject to fan
+// their events into a single merged stream. onDropped, if non-nil, is
+// called whenever a forward goroutine falls behind and has an item
+// evicted out from under it, so EventPublisher can count it as a metric.
+func newSubscription(req *SubscribeRequest, heads map[topicSubject]*bufferItem, unsubscribe func(sub *Subscription), onDropped func()) *Subscription {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	subjects := make([]topicSubject, 0, len(heads))
+	for subject := range heads {
+		subjects = append(subjects, subject)
+	}
+
+	s := &Subscription{
+		req:           req,
+		subjects:      subjects,
+		updateCh:      make(chan subscriptionUpdate, len(heads)),
+		ctx:           ctx,
+		cancel:        cancel,
+		forceClosed:   make(chan struct{}),
+		unsubscribeFn: unsubscribe,
+		onDropped:     onDropped,
+	}
+
+	for subject, item := range heads {
+		go s.forward(subject, item)
+	}
+
+	return s
+}
+
+// forward reads sequential items from a single subject's buffer and
+// delivers their events onto updateCh until the subscription is closed or
+// the buffer reports an error (e.g. the subscriber fell behind and the
+// item it needed was dropped from the buffer).
+func (s *Subscription) forward(subject topicSubject, item *bufferItem) {
+	for {
+		next, err := item.Next(s.ctx, s.forceClosed)
+		if err != nil {
+			if errors.Is(err, ErrItemDropped) && s.onDropped != nil {
+				s.onDropped()
+			}
+			select {
+			case s.updateCh <- subscriptionUpdate{err: err}:
+			case <-s.ctx.Done():
+			}
+			return
+		}
+		item = next
 
+		if len(item.Events) == 0 {
+			continue
+		}
+
+		atomic.StoreUint64(&s.lastIndex, item.Index)
+
+		select {
+		case s.updateCh <- subscriptionUpdate{events: item.Events}:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// Lag returns how far behind tailIndex (typically a topic buffer's current
+// tail Index) this subscription's most recently delivered item is.
+func (s *Subscription) Lag(tailIndex uint64) uint64 {
+	last := atomic.LoadUint64(&s.lastIndex)
+	if tailIndex <= last {
+		return 0
+	}
+	return tailIndex - last
+}
+
+// Next returns the next batch of events for any of the subscription's
+// subjects, blocking until one is available, ctx is cancelled, or the
+// subscription is closed. Subject-based filtering already happened before
+// the event reached its buffer; Next only has to filter out events the
+// subscription's Authorizer isn't allowed to read.
 func (s *Subscription) Next(ctx context.Context) ([]Event, error) {
 	if atomic.LoadUint32(&s.state) == subscriptionStateClosed {
 		return nil, ErrSubscriptionClosed
 	}
 
 	for {
-		next, err := s.currentItem.Next(ctx, s.forceClosed)
-		switch {
-		case err != nil && atomic.LoadUint32(&s.state) == subscriptionStateClosed:
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-s.forceClosed:
 			return nil, ErrSubscriptionClosed
-		case err != nil:
-			return nil, err
-		}
-		s.currentItem = next
+		case update := <-s.updateCh:
+			if update.err != nil {
+				if atomic.LoadUint32(&s.state) == subscriptionStateClosed {
+					return nil, ErrSubscriptionClosed
+				}
+				return nil, update.err
+			}
 
-		events := filter(s.req, next.Events)
-		if len(events) == 0 {
-			continue
+			events := filterACL(s.req.Authorizer, update.events)
+			if len(events) == 0 {
+				continue
+			}
+			return events, nil
 		}
-		return events, nil
 	}
 }
 
-// filter events to only those that match a subscriptions topic/keys
-func filter(req *SubscribeRequest, events []Event) []Event {
-	if len(events) == 0 {
+// filterACL drops events whose Payload implements ACLPayload and denies
+// authz read access. Payloads that don't implement ACLPayload, and
+// subscriptions with no Authorizer, pass through unfiltered.
+func filterACL(authz Authorizer, events []Event) []Event {
+	if authz == nil || len(events) == 0 {
 		return events
 	}
 
 	var count int
 	for _, e := range events {
-		if _, ok := req.topics[e.Topic]; ok {
-			for _, k := range req.topics[e.Topic] {
-				if e.Key == k || k == AllKeys {
-					count++
-				}
-			}
+		if p, ok := e.Payload.(ACLPayload); !ok || p.HasReadPermission(authz) {
+			count++
 		}
 	}
 
-	// Only allocate a new slice if some events need to be filtered out
 	switch count {
 	case 0:
 		return nil
@@ -101,16 +233,31 @@ func filter(req *SubscribeRequest, events []Event) []Event {
 		return events
 	}
 
-	// Return filtered events
 	result := make([]Event, 0, count)
 	for _, e := range events {
-		if _, ok := req.topics[e.Topic]; ok {
-			for _, k := range req.topics[e.Topic] {
-				if e.Key == k || k == AllKeys {
-					result = append(result, e)
-				}
-			}
+		if p, ok := e.Payload.(ACLPayload); !ok || p.HasReadPermission(authz) {
+			result = append(result, e)
 		}
 	}
 	return result
 }
+
+// Unsubscribe releases the subscription's reference on every subject
+// buffer it was bound to, and its entry in the publisher's token index if
+// it has one. It is safe to call more than once.
+func (s *Subscription) Unsubscribe() {
+	s.unsubscribeOnce.Do(func() {
+		s.cancel()
+		s.unsubscribeFn(s)
+	})
+}
+
+// forceClose closes the subscription and unblocks any current or future
+// call to Next with ErrSubscriptionClosed. Unlike Unsubscribe, it does not
+// release the subscription's buffer references; it's intended for callers
+// (like EventPublisher) that drop the Subscription entirely afterwards.
+func (s *Subscription) forceClose() {
+	if atomic.CompareAndSwapUint32(&s.state, subscriptionStateOpen, subscriptionStateClosed) {
+		close(s.forceClosed)
+	}
+}